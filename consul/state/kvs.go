@@ -72,6 +72,7 @@ func (s *StateStore) KVSSet(idx uint64, entry *structs.DirEntry) error {
 		return err
 	}
 
+	tx.Defer(func() { s.kvsWatch.Notify(entry.Key, false) })
 	tx.Commit()
 	return nil
 }
@@ -114,7 +115,9 @@ func (s *StateStore) kvsSetTxn(tx *memdb.Txn, idx uint64, entry *structs.DirEntr
 		return fmt.Errorf("failed updating index: %s", err)
 	}
 
-	tx.Defer(func() { s.kvsWatch.Notify(entry.Key, false) })
+	tx.Defer(func() {
+		s.kvsSubs.publish(KVEvent{Op: KVEventUpsert, Key: entry.Key, Entry: entry, ModifyIndex: entry.ModifyIndex})
+	})
 	return nil
 }
 
@@ -272,6 +275,7 @@ func (s *StateStore) KVSDelete(idx uint64, key string) error {
 		return err
 	}
 
+	tx.Defer(func() { s.kvsWatch.Notify(key, false) })
 	tx.Commit()
 	return nil
 }
@@ -301,7 +305,9 @@ func (s *StateStore) kvsDeleteTxn(tx *memdb.Txn, idx uint64, key string) error {
 		return fmt.Errorf("failed updating index: %s", err)
 	}
 
-	tx.Defer(func() { s.kvsWatch.Notify(key, false) })
+	tx.Defer(func() {
+		s.kvsSubs.publish(KVEvent{Op: KVEventDelete, Key: key, ModifyIndex: idx})
+	})
 	return nil
 }
 
@@ -332,6 +338,7 @@ func (s *StateStore) KVSDeleteCAS(idx, cidx uint64, key string) (bool, error) {
 		return false, err
 	}
 
+	tx.Defer(func() { s.kvsWatch.Notify(key, false) })
 	tx.Commit()
 	return true, nil
 }
@@ -368,6 +375,7 @@ func (s *StateStore) KVSSetCAS(idx uint64, entry *structs.DirEntry) (bool, error
 		return false, err
 	}
 
+	tx.Defer(func() { s.kvsWatch.Notify(entry.Key, false) })
 	tx.Commit()
 	return true, nil
 }
@@ -410,6 +418,12 @@ func (s *StateStore) KVSDeleteTree(idx uint64, prefix string) error {
 	// Update the index
 	if modified {
 		tx.Defer(func() { s.kvsWatch.Notify(prefix, true) })
+		for _, obj := range objs {
+			key := obj.(*structs.DirEntry).Key
+			tx.Defer(func() {
+				s.kvsSubs.publish(KVEvent{Op: KVEventDelete, Key: key, ModifyIndex: idx})
+			})
+		}
 		if err := tx.Insert("index", &IndexEntry{"kvs", idx}); err != nil {
 			return fmt.Errorf("failed updating index: %s", err)
 		}
@@ -477,6 +491,7 @@ func (s *StateStore) KVSLock(idx uint64, entry *structs.DirEntry) (bool, error)
 		return false, err
 	}
 
+	tx.Defer(func() { s.kvsWatch.Notify(entry.Key, false) })
 	tx.Commit()
 	return true, nil
 }
@@ -520,6 +535,264 @@ func (s *StateStore) KVSUnlock(idx uint64, entry *structs.DirEntry) (bool, error
 		return false, err
 	}
 
+	tx.Defer(func() { s.kvsWatch.Notify(entry.Key, false) })
 	tx.Commit()
 	return true, nil
 }
+
+// KVSOpError is returned by KVSAtomic when one of the operations in the
+// batch can't be applied. Index identifies the offending op's position
+// in the slice that was passed in, so callers (and ultimately the RPC
+// layer) can report exactly which op in the batch was rejected.
+type KVSOpError struct {
+	Index int
+	Op    *structs.KVSOp
+	Err   error
+}
+
+func (e *KVSOpError) Error() string {
+	return fmt.Sprintf("error in KVS op %d (%s %q): %s", e.Index, e.Op.Verb, e.Op.DirEnt.Key, e.Err)
+}
+
+// KVSAtomic applies a batch of KV operations as a single memdb
+// transaction. Either every op succeeds or none of them do - if any op
+// fails we abort the whole transaction, so there's no partial state, no
+// stray tombstones and no watch notifications left behind. On success,
+// the returned slice has one entry per op, holding the DirEntry read
+// back by KVSGet/KVSCheckIndex/KVSCheckSession and nil for every other
+// verb.
+//
+// Watch notifications are deferred until commit and coalesced per key
+// (or per prefix, for delete-tree), so a transaction touching N keys
+// wakes up each watcher exactly once rather than N times.
+func (s *StateStore) KVSAtomic(idx uint64, ops []*structs.KVSOp) ([]*structs.DirEntry, error) {
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	results := make([]*structs.DirEntry, len(ops))
+	notify := make(map[string]bool)
+	for i, op := range ops {
+		result, note, err := s.kvsApplyOpTxn(tx, idx, op)
+		if err != nil {
+			return nil, &KVSOpError{Index: i, Op: op, Err: err}
+		}
+		results[i] = result
+
+		// Read-only verbs (get/check-index/check-session) and
+		// no-op delete-trees return a nil note, so they're left out
+		// of the notify set entirely - they never touched the store,
+		// so there's nothing for a blocking query to wake up for.
+		if note == nil {
+			continue
+		}
+
+		// A prefix-wide notification (delete-tree) always wins over a
+		// single-key one for the same watch key.
+		if cur, ok := notify[note.Key]; !ok || (!cur && note.Prefix) {
+			notify[note.Key] = note.Prefix
+		}
+	}
+
+	for key, prefix := range notify {
+		key, prefix := key, prefix
+		tx.Defer(func() { s.kvsWatch.Notify(key, prefix) })
+	}
+
+	tx.Commit()
+	return results, nil
+}
+
+// kvsNotify describes the watch wakeup a single KVSOp needs once its
+// transaction commits. A nil *kvsNotify means the op didn't change
+// anything a blocking query could care about (a read, or a delete-tree
+// that matched no keys) and should be left out of the batch's notify
+// set entirely.
+type kvsNotify struct {
+	Key    string
+	Prefix bool
+}
+
+// kvsApplyOpTxn applies a single KVSOp within tx and returns the
+// DirEntry to surface for read-style verbs (nil for everything else),
+// the watch notification it needs (nil for ops that didn't write
+// anything), and any error. It never commits or aborts tx - that's left
+// to the caller so a whole batch can be applied atomically.
+func (s *StateStore) kvsApplyOpTxn(tx *memdb.Txn, idx uint64, op *structs.KVSOp) (*structs.DirEntry, *kvsNotify, error) {
+	switch op.Verb {
+	case structs.KVSSet:
+		entry := op.DirEnt
+		if err := s.kvsSetTxn(tx, idx, &entry, false); err != nil {
+			return nil, nil, err
+		}
+		return nil, &kvsNotify{Key: entry.Key}, nil
+
+	case structs.KVSCAS:
+		existing, err := tx.First("kvs", "id", op.DirEnt.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		if op.DirEnt.ModifyIndex == 0 && existing != nil {
+			return nil, nil, fmt.Errorf("failed to set key %q, key already has a value", op.DirEnt.Key)
+		}
+		if op.DirEnt.ModifyIndex != 0 && existing == nil {
+			return nil, nil, fmt.Errorf("failed to set key %q, key does not exist", op.DirEnt.Key)
+		}
+		if e, ok := existing.(*structs.DirEntry); ok && op.DirEnt.ModifyIndex != 0 &&
+			op.DirEnt.ModifyIndex != e.ModifyIndex {
+			return nil, nil, fmt.Errorf("failed to set key %q, index is stale", op.DirEnt.Key)
+		}
+		entry := op.DirEnt
+		if err := s.kvsSetTxn(tx, idx, &entry, false); err != nil {
+			return nil, nil, err
+		}
+		return nil, &kvsNotify{Key: entry.Key}, nil
+
+	case structs.KVSLock:
+		entry := op.DirEnt
+		if entry.Session == "" {
+			return nil, nil, fmt.Errorf("missing session")
+		}
+		sess, err := tx.First("sessions", "id", entry.Session)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed session lookup: %s", err)
+		}
+		if sess == nil {
+			return nil, nil, fmt.Errorf("invalid session %#v", entry.Session)
+		}
+		existing, err := tx.First("kvs", "id", entry.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		if existing != nil {
+			e := existing.(*structs.DirEntry)
+			if e.Session != "" && e.Session != entry.Session {
+				return nil, nil, fmt.Errorf("failed to lock key %q, lock is already held", entry.Key)
+			}
+			entry.CreateIndex = e.CreateIndex
+			if e.Session == entry.Session {
+				entry.LockIndex = e.LockIndex
+			} else {
+				entry.LockIndex = e.LockIndex + 1
+			}
+		} else {
+			entry.CreateIndex = idx
+			entry.LockIndex = 1
+		}
+		entry.ModifyIndex = idx
+		if err := s.kvsSetTxn(tx, idx, &entry, true); err != nil {
+			return nil, nil, err
+		}
+		return nil, &kvsNotify{Key: entry.Key}, nil
+
+	case structs.KVSUnlock:
+		entry := op.DirEnt
+		existing, err := tx.First("kvs", "id", entry.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		e, ok := existing.(*structs.DirEntry)
+		if !ok || e.Session != entry.Session {
+			return nil, nil, fmt.Errorf("failed to unlock key %q, lock is not held", entry.Key)
+		}
+		entry.Session = ""
+		entry.LockIndex = e.LockIndex
+		entry.CreateIndex = e.CreateIndex
+		entry.ModifyIndex = idx
+		if err := s.kvsSetTxn(tx, idx, &entry, true); err != nil {
+			return nil, nil, err
+		}
+		return nil, &kvsNotify{Key: entry.Key}, nil
+
+	case structs.KVSGet:
+		existing, err := tx.First("kvs", "id", op.DirEnt.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		if existing == nil {
+			return nil, nil, nil
+		}
+		return existing.(*structs.DirEntry), nil, nil
+
+	case structs.KVSCheckIndex:
+		existing, err := tx.First("kvs", "id", op.DirEnt.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		e, ok := existing.(*structs.DirEntry)
+		if !ok || e.ModifyIndex != op.DirEnt.ModifyIndex {
+			return nil, nil, fmt.Errorf("failed to check index for key %q, current index is not %d",
+				op.DirEnt.Key, op.DirEnt.ModifyIndex)
+		}
+		return e, nil, nil
+
+	case structs.KVSCheckSession:
+		existing, err := tx.First("kvs", "id", op.DirEnt.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		e, ok := existing.(*structs.DirEntry)
+		if !ok || e.Session != op.DirEnt.Session {
+			return nil, nil, fmt.Errorf("failed to check session for key %q, current session is not %q",
+				op.DirEnt.Key, op.DirEnt.Session)
+		}
+		return e, nil, nil
+
+	case structs.KVSDelete:
+		if err := s.kvsDeleteTxn(tx, idx, op.DirEnt.Key); err != nil {
+			return nil, nil, err
+		}
+		return nil, &kvsNotify{Key: op.DirEnt.Key}, nil
+
+	case structs.KVSDeleteCAS:
+		existing, err := tx.First("kvs", "id", op.DirEnt.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		e, ok := existing.(*structs.DirEntry)
+		if !ok || e.ModifyIndex != op.DirEnt.ModifyIndex {
+			return nil, nil, fmt.Errorf("failed to delete key %q, index is stale", op.DirEnt.Key)
+		}
+		if err := s.kvsDeleteTxn(tx, idx, op.DirEnt.Key); err != nil {
+			return nil, nil, err
+		}
+		return nil, &kvsNotify{Key: op.DirEnt.Key}, nil
+
+	case structs.KVSDeleteTree:
+		entries, err := tx.Get("kvs", "id_prefix", op.DirEnt.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed kvs lookup: %s", err)
+		}
+		var objs []interface{}
+		for entry := entries.Next(); entry != nil; entry = entries.Next() {
+			e := entry.(*structs.DirEntry)
+			if err := s.kvsGraveyard.InsertTxn(tx, e.Key, idx); err != nil {
+				return nil, nil, fmt.Errorf("failed adding to graveyard: %s", err)
+			}
+			objs = append(objs, entry)
+		}
+		for _, obj := range objs {
+			if err := tx.Delete("kvs", obj); err != nil {
+				return nil, nil, fmt.Errorf("failed deleting kvs entry: %s", err)
+			}
+		}
+		if len(objs) == 0 {
+			// Nothing matched the prefix, so there's no watch to wake
+			// and no index bump to make - mirrors the standalone
+			// KVSDeleteTree, which only notifies `if modified`.
+			return nil, nil, nil
+		}
+		for _, obj := range objs {
+			key := obj.(*structs.DirEntry).Key
+			tx.Defer(func() {
+				s.kvsSubs.publish(KVEvent{Op: KVEventDelete, Key: key, ModifyIndex: idx})
+			})
+		}
+		if err := tx.Insert("index", &IndexEntry{"kvs", idx}); err != nil {
+			return nil, nil, fmt.Errorf("failed updating index: %s", err)
+		}
+		return nil, &kvsNotify{Key: op.DirEnt.Key, Prefix: true}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown KVS verb %q", op.Verb)
+	}
+}
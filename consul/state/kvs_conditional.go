@@ -0,0 +1,177 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hashicorp/consul/consul/structs"
+)
+
+// KVSCondition expresses the preconditions KVSConditional checks
+// against a key's existing entry (if any) before applying an update.
+// This generalizes the single ModifyIndex check used by KVSSetCAS and
+// KVSDeleteCAS so callers can do things like leader election with
+// fencing tokens, or "only update if the current value's SHA-256
+// equals X", without racing other writers. Every non-nil/non-zero
+// field is checked; a zero-value KVSCondition matches anything.
+type KVSCondition struct {
+	// ModifyIndex requires the existing entry's ModifyIndex to equal
+	// the given value; 0 means the key must not exist yet.
+	ModifyIndex *uint64
+
+	// Session requires the existing entry's Session to equal the given
+	// value; "" means the key must be unlocked.
+	Session *string
+
+	// FlagsMask and FlagsValue, when FlagsMask is non-zero, require
+	// (existing.Flags & FlagsMask) == FlagsValue.
+	FlagsMask  uint64
+	FlagsValue uint64
+
+	// Value requires the existing entry's Value to be byte-identical
+	// to it.
+	Value []byte
+
+	// ValueSHA256 requires the hex-encoded SHA-256 digest of the
+	// existing entry's Value to equal it.
+	ValueSHA256 string
+
+	// MaxValueSize requires the existing entry's Value to be smaller
+	// than this many bytes. Zero means no limit.
+	MaxValueSize int
+
+	// Delete, if true, removes the key instead of writing entry once
+	// every check above has passed. This is what folds KVSDeleteCAS
+	// into the same entry point as KVSSetCAS.
+	Delete bool
+}
+
+// KVSPreconditionFailedError is returned by KVSConditional when cond
+// doesn't match the current state of the key. RPC layers can use this
+// to tell a conflict (e.g. HTTP 409) apart from a genuine failure (e.g.
+// HTTP 500), which a bare error can't express.
+type KVSPreconditionFailedError struct {
+	Reason string
+}
+
+func (e *KVSPreconditionFailedError) Error() string {
+	return fmt.Sprintf("precondition failed: %s", e.Reason)
+}
+
+// KVSConditional generalizes KVSSetCAS and KVSDeleteCAS into a single
+// check-then-act operation: cond is evaluated against entry.Key's
+// existing entry, and only once every check in it passes does the
+// store apply entry (or, if cond.Delete is set, remove the key
+// instead). Returns a *KVSPreconditionFailedError when a check fails
+// and a plain error for anything else that goes wrong.
+func (s *StateStore) KVSConditional(idx uint64, entry *structs.DirEntry, cond *KVSCondition) error {
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("kvs", "id", entry.Key)
+	if err != nil {
+		return fmt.Errorf("failed kvs lookup: %s", err)
+	}
+	e, _ := existing.(*structs.DirEntry)
+
+	if err := kvsCheckCondition(e, cond); err != nil {
+		return err
+	}
+
+	if cond.Delete {
+		if e == nil {
+			return nil
+		}
+		if err := s.kvsDeleteTxn(tx, idx, entry.Key); err != nil {
+			return err
+		}
+	} else {
+		if err := s.kvsSetTxn(tx, idx, entry, false); err != nil {
+			return err
+		}
+	}
+
+	tx.Defer(func() { s.kvsWatch.Notify(entry.Key, false) })
+	tx.Commit()
+	return nil
+}
+
+// kvsCheckCondition evaluates cond against existing, which is nil when
+// the key doesn't currently exist.
+func kvsCheckCondition(existing *structs.DirEntry, cond *KVSCondition) error {
+	if cond.ModifyIndex != nil {
+		var got uint64
+		if existing != nil {
+			got = existing.ModifyIndex
+		}
+		if got != *cond.ModifyIndex {
+			return &KVSPreconditionFailedError{
+				Reason: fmt.Sprintf("ModifyIndex is %d, expected %d", got, *cond.ModifyIndex),
+			}
+		}
+	}
+
+	if cond.Session != nil {
+		var got string
+		if existing != nil {
+			got = existing.Session
+		}
+		if got != *cond.Session {
+			return &KVSPreconditionFailedError{
+				Reason: fmt.Sprintf("Session is %q, expected %q", got, *cond.Session),
+			}
+		}
+	}
+
+	if cond.FlagsMask != 0 {
+		var got uint64
+		if existing != nil {
+			got = existing.Flags
+		}
+		if got&cond.FlagsMask != cond.FlagsValue {
+			return &KVSPreconditionFailedError{Reason: "Flags do not match"}
+		}
+	}
+
+	if cond.Value != nil {
+		var got []byte
+		if existing != nil {
+			got = existing.Value
+		}
+		if !bytes.Equal(got, cond.Value) {
+			return &KVSPreconditionFailedError{Reason: "Value does not match"}
+		}
+	}
+
+	if cond.ValueSHA256 != "" {
+		var value []byte
+		if existing != nil {
+			value = existing.Value
+		}
+		// sha256.Sum256(nil) is the well-defined digest of an absent
+		// or empty value, so hash it unconditionally rather than
+		// special-casing existing == nil to the zero array - that
+		// zero array isn't the hash of anything and could never match
+		// a caller's honestly precomputed digest.
+		sum := sha256.Sum256(value)
+		if hex.EncodeToString(sum[:]) != cond.ValueSHA256 {
+			return &KVSPreconditionFailedError{Reason: "Value hash does not match"}
+		}
+	}
+
+	if cond.MaxValueSize != 0 {
+		var size int
+		if existing != nil {
+			size = len(existing.Value)
+		}
+		if size >= cond.MaxValueSize {
+			return &KVSPreconditionFailedError{
+				Reason: fmt.Sprintf("Value size %d is not less than %d", size, cond.MaxValueSize),
+			}
+		}
+	}
+
+	return nil
+}
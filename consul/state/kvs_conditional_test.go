@@ -0,0 +1,39 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/hashicorp/consul/consul/structs"
+)
+
+func TestKVSCheckCondition_ValueSHA256_MissingKey(t *testing.T) {
+	empty := sha256.Sum256(nil)
+	cond := &KVSCondition{ValueSHA256: hex.EncodeToString(empty[:])}
+
+	if err := kvsCheckCondition(nil, cond); err != nil {
+		t.Fatalf("expected the empty-input digest to satisfy a not-yet-created key, got: %s", err)
+	}
+}
+
+func TestKVSCheckCondition_ValueSHA256_Mismatch(t *testing.T) {
+	existing := &structs.DirEntry{Value: []byte("hello")}
+	wrong := sha256.Sum256([]byte("goodbye"))
+	cond := &KVSCondition{ValueSHA256: hex.EncodeToString(wrong[:])}
+
+	err := kvsCheckCondition(existing, cond)
+	if _, ok := err.(*KVSPreconditionFailedError); !ok {
+		t.Fatalf("expected a precondition failure, got: %v", err)
+	}
+}
+
+func TestKVSCheckCondition_ValueSHA256_Match(t *testing.T) {
+	existing := &structs.DirEntry{Value: []byte("hello")}
+	sum := sha256.Sum256(existing.Value)
+	cond := &KVSCondition{ValueSHA256: hex.EncodeToString(sum[:])}
+
+	if err := kvsCheckCondition(existing, cond); err != nil {
+		t.Fatalf("expected the matching digest to satisfy the condition, got: %s", err)
+	}
+}
@@ -0,0 +1,279 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/consul/structs"
+)
+
+// KVEventOp distinguishes the kind of change a KVEvent represents.
+type KVEventOp int
+
+const (
+	// KVEventUpsert is sent for both creates and updates; Entry holds
+	// the resulting DirEntry.
+	KVEventUpsert KVEventOp = iota
+
+	// KVEventDelete is sent when a key is removed, either directly or
+	// as part of a delete-tree. Entry is nil; Key and ModifyIndex (the
+	// tombstone's index) are all that's left to report.
+	KVEventDelete
+)
+
+// KVEvent describes a single change to a key under watch.
+type KVEvent struct {
+	Op          KVEventOp
+	Key         string
+	Entry       *structs.DirEntry
+	ModifyIndex uint64
+}
+
+// kvsSubscription is a single KVSSubscribe caller's view onto the change
+// feed. publish() never writes to sub.events directly - it only queues
+// into sub.queue - because the goroutine KVSSubscribe spawns is the sole
+// reader of that queue and the sole writer to (and closer of) events.
+// That single-writer rule is what lets the owning goroutine close events
+// without ever racing a send: nothing else ever sends on it.
+type kvsSubscription struct {
+	prefix string
+	events chan KVEvent
+	signal chan struct{}
+
+	mu      sync.Mutex
+	queue   []KVEvent
+	dropped bool
+}
+
+func newKVSSubscription(prefix string) *kvsSubscription {
+	return &kvsSubscription{
+		prefix: prefix,
+		events: make(chan KVEvent, 256),
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues ev for the owning goroutine to forward and reports
+// whether it fit. A false return means the subscriber has fallen far
+// enough behind that the queue is at the channel's capacity; the caller
+// must drop the subscription rather than let the queue grow without
+// bound.
+func (sub *kvsSubscription) enqueue(ev KVEvent) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if len(sub.queue) >= cap(sub.events) {
+		return false
+	}
+	sub.queue = append(sub.queue, ev)
+
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// takeQueue returns and clears whatever's queued so far, plus whether
+// the subscription has been dropped and its goroutine should exit (which
+// triggers the deferred close of events - the only place events is ever
+// closed).
+func (sub *kvsSubscription) takeQueue() ([]KVEvent, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	q := sub.queue
+	sub.queue = nil
+	return q, sub.dropped
+}
+
+// drop marks the subscription as dropped and wakes its goroutine so it
+// can exit and close events. Safe to call more than once.
+func (sub *kvsSubscription) drop() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.dropped = true
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+// kvsSubscriptions tracks the live KVSSubscribe subscribers so committed
+// writes can be fanned out to them without going through the coarser,
+// single-shot kvsWatch.
+type kvsSubscriptions struct {
+	lock sync.Mutex
+	subs map[*kvsSubscription]struct{}
+}
+
+func newKVSSubscriptions() *kvsSubscriptions {
+	return &kvsSubscriptions{subs: make(map[*kvsSubscription]struct{})}
+}
+
+func (r *kvsSubscriptions) add(sub *kvsSubscription) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.subs[sub] = struct{}{}
+}
+
+func (r *kvsSubscriptions) remove(sub *kvsSubscription) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.subs, sub)
+}
+
+// publish fans ev out to every subscription whose prefix matches the
+// event's key. This is called from inside tx.Defer callbacks, after the
+// write that produced ev has committed, so subscribers never see
+// speculative state. It only ever queues ev for the subscription's own
+// goroutine to forward - it never touches sub.events - so a write storm
+// arriving while a subscriber is still being replayed its backlog can't
+// race that backlog for buffer space, and can't require closing the
+// channel from here either.
+func (r *kvsSubscriptions) publish(ev KVEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for sub := range r.subs {
+		if !strings.HasPrefix(ev.Key, sub.prefix) {
+			continue
+		}
+		if !sub.enqueue(ev) {
+			// Slow consumer - drop it instead of letting the queue grow
+			// without bound. drop() wakes the subscription's own
+			// goroutine, which is the only thing that ever closes
+			// events, so the caller sees it closed and knows to
+			// reconnect with a fresh fromIndex.
+			delete(r.subs, sub)
+			sub.drop()
+		}
+	}
+}
+
+// KVSSubscribe streams create/update/delete events for a single key or
+// an entire prefix. The subscription first replays everything that
+// changed since fromIndex by walking the current entries and the
+// graveyard tombstones, so a consumer that's reconnecting can catch up
+// deterministically instead of polling KVSList in a loop; it then
+// switches to live events as they're committed. The returned channel is
+// closed when ctx is done or when the caller falls behind and is
+// dropped.
+//
+// Replay is delivered in full before any live event queued during the
+// replay window: publish() only ever queues onto the subscription, it
+// never writes to the returned channel directly, so there's no scan
+// here racing a concurrent write for buffer space. Because the replay
+// snapshot and the live feed can still overlap briefly around the
+// subscribe call, a consumer may observe the same ModifyIndex twice;
+// events are otherwise delivered in increasing ModifyIndex order within
+// the replay and as-committed order live.
+func (s *StateStore) KVSSubscribe(ctx context.Context, prefix string, fromIndex uint64) (<-chan KVEvent, error) {
+	sub := newKVSSubscription(prefix)
+
+	// Register before reading the snapshot below so we don't miss
+	// anything that commits while we're walking it - it'll just queue
+	// up behind the replay batch instead.
+	s.kvsSubs.add(sub)
+
+	replay, err := s.kvsSubscribeReplay(prefix, fromIndex)
+	if err != nil {
+		s.kvsSubs.remove(sub)
+		return nil, err
+	}
+
+	go func() {
+		defer func() {
+			s.kvsSubs.remove(sub)
+			close(sub.events)
+		}()
+
+		// sub.events is only ever sent to or closed from this
+		// goroutine, so blocking here is safe: a slow consumer just
+		// makes us wait, instead of racing a concurrent close.
+		send := func(ev KVEvent) bool {
+			select {
+			case sub.events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, ev := range replay {
+			if !send(ev) {
+				return
+			}
+		}
+
+		for {
+			queued, dropped := sub.takeQueue()
+			for _, ev := range queued {
+				if !send(ev) {
+					return
+				}
+			}
+			if dropped {
+				return
+			}
+
+			select {
+			case <-sub.signal:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return sub.events, nil
+}
+
+// kvsSubscribeReplay gathers every KVEvent needed to bring a subscriber
+// that's reconnecting up to date: entries currently in the store plus
+// graveyard tombstones, both filtered to the given prefix and to
+// indexes newer than fromIndex, sorted by ModifyIndex.
+func (s *StateStore) kvsSubscribeReplay(prefix string, fromIndex uint64) ([]KVEvent, error) {
+	tx := s.db.Txn(false)
+	defer tx.Abort()
+
+	var replay []KVEvent
+
+	entries, err := tx.Get("kvs", "id_prefix", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed kvs lookup: %s", err)
+	}
+	for entry := entries.Next(); entry != nil; entry = entries.Next() {
+		e := entry.(*structs.DirEntry)
+		if e.ModifyIndex > fromIndex {
+			replay = append(replay, KVEvent{
+				Op:          KVEventUpsert,
+				Key:         e.Key,
+				Entry:       e,
+				ModifyIndex: e.ModifyIndex,
+			})
+		}
+	}
+
+	stones, err := s.kvsGraveyard.DumpTxn(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed graveyard lookup: %s", err)
+	}
+	for stone := stones.Next(); stone != nil; stone = stones.Next() {
+		t := stone.(*Tombstone)
+		if t.Index <= fromIndex || !strings.HasPrefix(t.Key, prefix) {
+			continue
+		}
+		replay = append(replay, KVEvent{
+			Op:          KVEventDelete,
+			Key:         t.Key,
+			ModifyIndex: t.Index,
+		})
+	}
+
+	sort.Slice(replay, func(i, j int) bool {
+		return replay[i].ModifyIndex < replay[j].ModifyIndex
+	})
+	return replay, nil
+}
@@ -0,0 +1,54 @@
+package state
+
+import "testing"
+
+func TestKVSSubscriptions_Publish_PrefixFilter(t *testing.T) {
+	subs := newKVSSubscriptions()
+
+	match := newKVSSubscription("foo/")
+	other := newKVSSubscription("bar/")
+	subs.add(match)
+	subs.add(other)
+
+	subs.publish(KVEvent{Op: KVEventUpsert, Key: "foo/a", ModifyIndex: 1})
+
+	queued, dropped := match.takeQueue()
+	if dropped {
+		t.Fatalf("matching subscription should not be dropped")
+	}
+	if len(queued) != 1 || queued[0].Key != "foo/a" {
+		t.Fatalf("expected foo/a queued for the matching prefix, got %#v", queued)
+	}
+
+	queued, _ = other.takeQueue()
+	if len(queued) != 0 {
+		t.Fatalf("expected nothing queued for the non-matching prefix, got %#v", queued)
+	}
+}
+
+func TestKVSSubscriptions_Publish_DropsSlowConsumer(t *testing.T) {
+	subs := newKVSSubscriptions()
+	sub := newKVSSubscription("foo/")
+	subs.add(sub)
+
+	capacity := cap(sub.events)
+	for i := 0; i < capacity; i++ {
+		subs.publish(KVEvent{Op: KVEventUpsert, Key: "foo/a", ModifyIndex: uint64(i)})
+	}
+	if _, ok := subs.subs[sub]; !ok {
+		t.Fatalf("subscription should still be registered once the queue is exactly full")
+	}
+
+	subs.publish(KVEvent{Op: KVEventUpsert, Key: "foo/a", ModifyIndex: uint64(capacity)})
+	if _, ok := subs.subs[sub]; ok {
+		t.Fatalf("subscription should have been dropped once its queue overflowed")
+	}
+
+	queued, dropped := sub.takeQueue()
+	if !dropped {
+		t.Fatalf("expected the subscription to be marked dropped")
+	}
+	if len(queued) != capacity {
+		t.Fatalf("expected the queue to still hold the %d events that fit, got %d", capacity, len(queued))
+	}
+}
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/consul/structs"
+	"github.com/hashicorp/go-memdb"
+)
+
+// kvsExpiresIndex indexes kvs entries by their ExpiresAt field (wired in
+// as the "expires" index on the "kvs" table) so the reaper can scan in
+// expiration order without a full table sweep. Entries with no TTL
+// (ExpiresAt == 0) are left out of the index entirely, so the only cost
+// is proportional to the keys that actually have one.
+type kvsExpiresIndex struct{}
+
+func (i *kvsExpiresIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	e, ok := obj.(*structs.DirEntry)
+	if !ok {
+		return false, nil, fmt.Errorf("invalid type %T for kvs expires index", obj)
+	}
+	if e.ExpiresAt == 0 {
+		return false, nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, e.ExpiresAt); err != nil {
+		return false, nil, err
+	}
+	return true, buf.Bytes(), nil
+}
+
+func (i *kvsExpiresIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide one argument")
+	}
+	v, ok := args[0].(uint64)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a uint64: %#v", args[0])
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// KVSRenew bumps an existing key's expiration to now + ttl, leaving its
+// value, flags and session untouched. It's an error to renew a key that
+// doesn't exist or that was never given a TTL.
+func (s *StateStore) KVSRenew(idx uint64, key string, ttl time.Duration) error {
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	existing, err := tx.First("kvs", "id", key)
+	if err != nil {
+		return fmt.Errorf("failed kvs lookup: %s", err)
+	}
+	e, ok := existing.(*structs.DirEntry)
+	if !ok {
+		return fmt.Errorf("key %q does not exist", key)
+	}
+	if e.ExpiresAt == 0 {
+		return fmt.Errorf("key %q has no TTL to renew", key)
+	}
+
+	entry := *e
+	entry.ExpiresAt = uint64(time.Now().Add(ttl).UnixNano())
+	if err := s.kvsSetTxn(tx, idx, &entry, false); err != nil {
+		return err
+	}
+
+	tx.Defer(func() { s.kvsWatch.Notify(key, false) })
+	tx.Commit()
+	return nil
+}
+
+// ExpireReaper deletes every kvs entry whose TTL is due as of now, all in
+// one transaction, using idx for every resulting tombstone/ModifyIndex.
+// Like ReapTombstones, this takes idx and now from the caller instead of
+// computing them locally: a replicated state machine's mutations have to
+// be a deterministic function of the committed raft log, so the ticker
+// that decides "it's time to reap" and picks the cutoff belongs in the
+// leader's periodic raft-apply loop, not here. A server that fabricated
+// its own index or read its own clock inside this method would reap a
+// different set of keys, at different indexes, than the rest of the
+// cluster.
+func (s *StateStore) ExpireReaper(idx uint64, now uint64) error {
+	tx := s.db.Txn(true)
+	defer tx.Abort()
+
+	for {
+		key, ok, err := s.nextExpiredKVSTxn(tx, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := s.kvsDeleteTxn(tx, idx, key); err != nil {
+			return err
+		}
+		tx.Defer(func() { s.kvsWatch.Notify(key, false) })
+	}
+
+	tx.Commit()
+	return nil
+}
+
+// nextExpiredKVSTxn returns the key of the earliest-expiring kvs entry
+// that's already due, by walking the "expires" index in ascending order
+// from zero and stopping at the first entry that isn't due yet. It reads
+// through tx so it sees tx's own prior deletes within the same
+// transaction, which is what lets ExpireReaper loop to a fixed point
+// without needing a fresh transaction per key. A non-nil error means the
+// scan itself failed (e.g. the "expires" index isn't wired up in the
+// schema) - that's distinct from "nothing is due" and must not be
+// swallowed, or a misconfigured schema looks identical to an idle store.
+func (s *StateStore) nextExpiredKVSTxn(tx *memdb.Txn, now uint64) (string, bool, error) {
+	iter, err := tx.LowerBound("kvs", "expires", uint64(0))
+	if err != nil {
+		return "", false, fmt.Errorf("failed kvs expires lookup: %s", err)
+	}
+
+	obj := iter.Next()
+	if obj == nil {
+		return "", false, nil
+	}
+	e := obj.(*structs.DirEntry)
+	if e.ExpiresAt > now {
+		return "", false, nil
+	}
+	return e.Key, true, nil
+}
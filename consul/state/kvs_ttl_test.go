@@ -0,0 +1,54 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/consul/consul/structs"
+)
+
+func TestKVSExpiresIndex_FromObject_NoTTLExcluded(t *testing.T) {
+	idx := &kvsExpiresIndex{}
+
+	ok, _, err := idx.FromObject(&structs.DirEntry{Key: "foo"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ok {
+		t.Fatalf("an entry with no TTL should be left out of the expires index")
+	}
+}
+
+func TestKVSExpiresIndex_FromObject_OrdersByExpiresAt(t *testing.T) {
+	idx := &kvsExpiresIndex{}
+
+	_, earlier, err := idx.FromObject(&structs.DirEntry{Key: "a", ExpiresAt: 100})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, later, err := idx.FromObject(&structs.DirEntry{Key: "b", ExpiresAt: 200})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if bytes.Compare(earlier, later) >= 0 {
+		t.Fatalf("expected the earlier ExpiresAt to sort before the later one")
+	}
+}
+
+func TestKVSExpiresIndex_FromArgs_RequiresOneUint64(t *testing.T) {
+	idx := &kvsExpiresIndex{}
+
+	if _, err := idx.FromArgs(); err == nil {
+		t.Fatalf("expected an error with no arguments")
+	}
+	if _, err := idx.FromArgs(uint64(0), uint64(1)); err == nil {
+		t.Fatalf("expected an error with more than one argument")
+	}
+	if _, err := idx.FromArgs("not a uint64"); err == nil {
+		t.Fatalf("expected an error for a non-uint64 argument")
+	}
+	if _, err := idx.FromArgs(uint64(0)); err != nil {
+		t.Fatalf("expected a single uint64 argument to be accepted, got: %s", err)
+	}
+}
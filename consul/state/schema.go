@@ -0,0 +1,42 @@
+package state
+
+import "github.com/hashicorp/go-memdb"
+
+// kvsTableSchema returns the memdb schema for the "kvs" table, which
+// backs all of the StateStore methods in kvs.go, kvs_subscribe.go,
+// kvs_ttl.go and kvs_conditional.go. It's consumed by the top-level
+// StateStore schema when the memdb handle is created.
+func kvsTableSchema() *memdb.TableSchema {
+	return &memdb.TableSchema{
+		Name: "kvs",
+		Indexes: map[string]*memdb.IndexSchema{
+			"id": &memdb.IndexSchema{
+				Name:         "id",
+				AllowMissing: false,
+				Unique:       true,
+				Indexer: &memdb.StringFieldIndex{
+					Field: "Key",
+				},
+			},
+			"id_prefix": &memdb.IndexSchema{
+				Name:         "id_prefix",
+				AllowMissing: false,
+				Unique:       false,
+				Indexer: &memdb.StringFieldIndex{
+					Field: "Key",
+				},
+			},
+
+			// expires lets ExpireReaper scan due entries in expiration
+			// order instead of sweeping the whole table. AllowMissing
+			// is required here because kvsExpiresIndex.FromObject
+			// deliberately leaves out entries with no TTL.
+			"expires": &memdb.IndexSchema{
+				Name:         "expires",
+				AllowMissing: true,
+				Unique:       false,
+				Indexer:      &kvsExpiresIndex{},
+			},
+		},
+	}
+}
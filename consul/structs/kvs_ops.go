@@ -0,0 +1,28 @@
+package structs
+
+// The following verbs are supported by KVSOp. They mirror the set of
+// single-key KVS RPC operations so a batch built for StateStore.KVSAtomic
+// can be assembled from the same primitives that back the individual
+// KVSSet/KVSSetCAS/KVSDelete/KVSLock endpoints.
+const (
+	KVSSet          = "set"
+	KVSCAS          = "cas"
+	KVSLock         = "lock"
+	KVSUnlock       = "unlock"
+	KVSGet          = "get"
+	KVSDelete       = "delete"
+	KVSDeleteCAS    = "delete-cas"
+	KVSDeleteTree   = "delete-tree"
+	KVSCheckIndex   = "check-index"
+	KVSCheckSession = "check-session"
+)
+
+// KVSOp is used to propose a single operation as part of an atomic batch
+// applied via StateStore.KVSAtomic. The Verb selects which of the above
+// operations to run, and DirEnt carries whatever fields that operation
+// needs (Key is always required; Value, Flags, Session and the raft
+// indexes are interpreted according to Verb).
+type KVSOp struct {
+	Verb   string
+	DirEnt DirEntry
+}
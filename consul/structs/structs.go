@@ -0,0 +1,30 @@
+package structs
+
+// RaftIndex is used to track the index used while creating
+// or modifying a given struct type.
+type RaftIndex struct {
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// DirEntry is used to represent a directory entry. This is
+// used to represent a key/value pair. The order of fields in the
+// struct is important for alignment purposes.
+type DirEntry struct {
+	LockIndex uint64
+	Key       string
+	Flags     uint64
+	Value     []byte
+	Session   string `json:",omitempty"`
+
+	// ExpiresAt is the UnixNano time at which this entry should be
+	// reaped by StateStore.ExpireReaper. It's 0 for entries with no
+	// TTL, which is also how they're left out of the state store's
+	// expiration index.
+	ExpiresAt uint64 `json:",omitempty"`
+
+	RaftIndex
+}
+
+// DirEntries is a list of DirEntry structs.
+type DirEntries []*DirEntry